@@ -3,6 +3,9 @@ package parsers
 import (
 	"bufio"
 	"bytes"
+	"fmt"
+	"io"
+	"strconv"
 	"strings"
 
 	"github.com/ntindall/moduluschecking/data"
@@ -10,6 +13,22 @@ import (
 	m "github.com/ntindall/moduluschecking/models"
 )
 
+const (
+	// A weights line is either "start,end,algorithm,w1..w14" or, when the
+	// range has an exception value, "start,end,algorithm,w1..w14,exception".
+	weightsFieldCount          = 17
+	weightsFieldCountException = 18
+	weightCount                = 14
+
+	sortCodeDigits = 6
+
+	// VocaLink's published weights are small single-digit multipliers;
+	// this bound is generous headroom against corrupt input rather than a
+	// precise domain limit.
+	minWeight = 0
+	maxWeight = 99
+)
+
 // Describes the content of a file.
 type LineRecord struct {
 	// The content of a line in the file
@@ -28,40 +47,83 @@ type FileParser struct {
 	weights map[string]m.SortCodeData
 }
 
-// Get all known sort code substitutions.
-func (fp FileParser) Substitutions() map[string]string {
+// Get all known sort code substitutions. Returns an error identifying the
+// offending line rather than indexing into a malformed line, so a caller
+// embedding this library in a service can reject bad data instead of
+// crashing on it.
+func (fp FileParser) Substitutions() (map[string]string, error) {
 	substitutions := make(map[string]string)
 
 	jobs := make(chan LineRecord)
-	go readFile(fp.substitutionsBytes, jobs)
+	readErrs := make(chan error, 1)
+	go func() { readErrs <- readFile(bytes.NewReader(fp.substitutionsBytes), jobs) }()
 
+	var firstErr error
 	for lineRecord := range jobs {
+		if firstErr != nil {
+			// Drain the remaining lines so readFile's goroutine never
+			// blocks sending on jobs.
+			continue
+		}
+
 		fields := strings.Split(lineRecord.content, " ")
-		key, value := fields[0], fields[1]
-		substitutions[key] = value
+		if len(fields) != 2 {
+			firstErr = fmt.Errorf("line %d: expected \"<sortcode> <substitution>\", got %q", lineRecord.lineNumber, lineRecord.content)
+			continue
+		}
+
+		substitutions[fields[0]] = fields[1]
+	}
+
+	if firstErr == nil {
+		firstErr = <-readErrs
+	}
+
+	if firstErr != nil {
+		return nil, fmt.Errorf("parsers: substitutions: %w", firstErr)
 	}
 
-	return substitutions
+	return substitutions, nil
 }
 
-// Get the weights, exception information and algorithm to use for all known sort codes.
-func (fp FileParser) Weights() map[string]m.SortCodeData {
+// Get the weights, exception information and algorithm to use for all
+// known sort codes. Returns an error identifying the offending line
+// rather than panicking on malformed input.
+func (fp FileParser) Weights() (map[string]m.SortCodeData, error) {
 	jobs := make(chan LineRecord)
 	results := make(chan m.SortCodeRange)
+	parseErrs := make(chan error, 1)
+	readErrs := make(chan error, 1)
 
-	go readFile(fp.weightsBytes, jobs)
-	go parseWeightsLine(jobs, results)
+	go func() { readErrs <- readFile(bytes.NewReader(fp.weightsBytes), jobs) }()
+	go parseWeightsLine(jobs, results, parseErrs)
 
 	// Process all the sort code ranges
 	for result := range results {
-		fp.addSortCodeRange(result)
+		if err := fp.addSortCodeRange(result); err != nil {
+			// Drain the remaining results so parseWeightsLine's goroutine
+			// never blocks sending on results.
+			for range results {
+			}
+			return nil, fmt.Errorf("parsers: weights: %w", err)
+		}
+	}
+
+	if err := <-parseErrs; err != nil {
+		return nil, fmt.Errorf("parsers: weights: %w", err)
 	}
 
-	return fp.weights
+	if err := <-readErrs; err != nil {
+		return nil, fmt.Errorf("parsers: weights: %w", err)
+	}
+
+	return fp.weights, nil
 }
 
-// Process a sort code range and add it to the weights map.
-func (fp *FileParser) addSortCodeRange(scRange m.SortCodeRange) {
+// Process a sort code range and add it to the weights map. Returns an
+// error if the range would make a sort code overlap more than two
+// ranges, since the Next chain only has room for two.
+func (fp *FileParser) addSortCodeRange(scRange m.SortCodeRange) error {
 	scData := m.SortCodeData{
 		Algorithm:      scRange.Algorithm,
 		Weights:        scRange.Weights,
@@ -81,6 +143,10 @@ func (fp *FileParser) addSortCodeRange(scRange m.SortCodeRange) {
 			continue
 		}
 
+		if val.Next != nil {
+			return fmt.Errorf("sort code %s (line %d) overlaps more than two ranges", key, scRange.LineNumber)
+		}
+
 		// Check that the first data structure was before in the weights file
 		if val.LineNumber < scData.LineNumber {
 			var tmp = val
@@ -94,51 +160,122 @@ func (fp *FileParser) addSortCodeRange(scRange m.SortCodeRange) {
 			fp.weights[key] = scData
 		}
 	}
+
+	return nil
 }
 
-// Parse lines from the weights file and put the result
-// as a SortCodeRange structure in a channel.
-func parseWeightsLine(jobs <-chan LineRecord, results chan<- m.SortCodeRange) {
-	var fields []string
+// Parse lines from the weights file and put the result as a
+// SortCodeRange structure in results. Stops at the first malformed line,
+// draining the remaining jobs without processing them, and reports that
+// line's error on errs.
+func parseWeightsLine(jobs <-chan LineRecord, results chan<- m.SortCodeRange, errs chan<- error) {
+	defer close(results)
+	defer close(errs)
 
+	var firstErr error
 	for lineRecord := range jobs {
-		lineNumber, data := lineRecord.lineNumber, lineRecord.content
-		fields = strings.Split(data, ",")
-		// Sort code range
-		sortCodeStart, sortCodeEnd := helpers.ToInt(fields[0]), helpers.ToInt(fields[1])
-		// Algorithm to use in order to perform the check
-		algorithm := fields[2]
-		// Weights for sort code and account number
-		weights := fields[3:17]
-
-		scRange := m.SortCodeRange{
-			Start:          sortCodeStart,
-			End:            sortCodeEnd,
-			Algorithm:      algorithm,
-			Weights:        helpers.StringSliceToIntSlice(weights),
-			ExceptionValue: 0,
-			LineNumber:     lineNumber,
+		if firstErr != nil {
+			continue
 		}
 
-		// Does this sort code range has got an exception?
-		hasException := len(fields) > (2 + 1 + 14)
-
-		// Set the exception value if needed
-		if hasException {
-			scRange.ExceptionValue = helpers.ToInt(fields[17])
+		scRange, err := parseWeightsLineFields(lineRecord)
+		if err != nil {
+			firstErr = err
+			continue
 		}
 
 		results <- scRange
 	}
 
-	close(results)
+	if firstErr != nil {
+		errs <- firstErr
+	}
 }
 
-// Read a file and put the content in a channel.
-func readFile(file []byte, jobs chan<- LineRecord) {
+// parseWeightsLineFields validates and parses a single weights line,
+// returning an error identifying lineRecord.lineNumber rather than
+// indexing into or converting fields that turn out to be malformed.
+func parseWeightsLineFields(lineRecord LineRecord) (m.SortCodeRange, error) {
+	fields := strings.Split(lineRecord.content, ",")
+	if len(fields) != weightsFieldCount && len(fields) != weightsFieldCountException {
+		return m.SortCodeRange{}, fmt.Errorf("line %d: expected %d or %d comma-separated fields, got %d", lineRecord.lineNumber, weightsFieldCount, weightsFieldCountException, len(fields))
+	}
+
+	sortCodeStart, err := parseSortCode(fields[0])
+	if err != nil {
+		return m.SortCodeRange{}, fmt.Errorf("line %d: range start: %w", lineRecord.lineNumber, err)
+	}
+
+	sortCodeEnd, err := parseSortCode(fields[1])
+	if err != nil {
+		return m.SortCodeRange{}, fmt.Errorf("line %d: range end: %w", lineRecord.lineNumber, err)
+	}
+
+	if sortCodeStart > sortCodeEnd {
+		return m.SortCodeRange{}, fmt.Errorf("line %d: range start %06d is after range end %06d", lineRecord.lineNumber, sortCodeStart, sortCodeEnd)
+	}
+
+	// Algorithm to use in order to perform the check
+	algorithm := fields[2]
+
+	// Weights for sort code and account number
+	weights := make([]int, weightCount)
+	for i, field := range fields[3 : 3+weightCount] {
+		weight, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || weight < minWeight || weight > maxWeight {
+			return m.SortCodeRange{}, fmt.Errorf("line %d: weight %d (%q) is not an integer between %d and %d", lineRecord.lineNumber, i, field, minWeight, maxWeight)
+		}
+		weights[i] = weight
+	}
+
+	scRange := m.SortCodeRange{
+		Start:          sortCodeStart,
+		End:            sortCodeEnd,
+		Algorithm:      algorithm,
+		Weights:        weights,
+		ExceptionValue: 0,
+		LineNumber:     lineRecord.lineNumber,
+	}
+
+	// Does this sort code range has got an exception?
+	if len(fields) == weightsFieldCountException {
+		exception, err := strconv.Atoi(strings.TrimSpace(fields[17]))
+		if err != nil {
+			return m.SortCodeRange{}, fmt.Errorf("line %d: exception value %q: %w", lineRecord.lineNumber, fields[17], err)
+		}
+		scRange.ExceptionValue = exception
+	}
+
+	return scRange, nil
+}
+
+// parseSortCode validates that field is a 6-digit numeric sort code and
+// returns its integer value.
+func parseSortCode(field string) (int, error) {
+	field = strings.TrimSpace(field)
+	if len(field) != sortCodeDigits {
+		return 0, fmt.Errorf("%q is not a %d-digit sort code", field, sortCodeDigits)
+	}
+
+	for _, r := range field {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("%q is not numeric", field)
+		}
+	}
+
+	return strconv.Atoi(field)
+}
+
+// Read lines from r and put the content in a channel. The channel is
+// closed once r is exhausted. Returns any error reported by the
+// underlying scanner (e.g. a line exceeding bufio.Scanner's token size
+// limit), so callers running this in a goroutine must capture it over a
+// channel, as Weights and Substitutions do, rather than let it vanish
+// with the goroutine's return value.
+func readFile(r io.Reader, jobs chan<- LineRecord) error {
 
 	lineNumber := 1
-	scanner := bufio.NewScanner(bytes.NewReader(file))
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		jobs <- LineRecord{
 			content:    scanner.Text(),
@@ -149,17 +286,46 @@ func readFile(file []byte, jobs chan<- LineRecord) {
 
 	// We are done with the file, release the channel
 	close(jobs)
+
+	return scanner.Err()
+}
+
+// newFileParserFromBytes is the constructor shared by CreateFileParser,
+// CreateFileParserFromReaders and CreateFileParserFromURL: whatever the
+// origin of the data, once it has been read into memory it is scanned the
+// same way.
+func newFileParserFromBytes(weights, substitutions []byte) FileParser {
+	return FileParser{
+		weightsBytes:       weights,
+		substitutionsBytes: substitutions,
+		weights:            make(map[string]m.SortCodeData),
+	}
 }
 
 // Create a new instance of a file parser that satisfies
-// the parser interface.
+// the parser interface, using the weights and substitutions files
+// embedded in the binary at build time.
 func CreateFileParser() m.Parser {
 	weights := data.MustAsset("data/weights.txt")
 	substitutions := data.MustAsset("data/substitutions.txt")
 
-	return FileParser{
-		weightsBytes:       weights,
-		substitutionsBytes: substitutions,
-		weights:            make(map[string]m.SortCodeData),
+	return newFileParserFromBytes(weights, substitutions)
+}
+
+// CreateFileParserFromReaders builds a Parser from arbitrary weights and
+// substitutions sources. This lets a caller supply reference data that
+// isn't baked into the binary, e.g. a copy of VocaLink's periodically
+// updated files fetched or managed outside this package.
+func CreateFileParserFromReaders(weights, substitutions io.Reader) (m.Parser, error) {
+	weightsBytes, err := io.ReadAll(weights)
+	if err != nil {
+		return nil, fmt.Errorf("parsers: reading weights: %w", err)
+	}
+
+	substitutionsBytes, err := io.ReadAll(substitutions)
+	if err != nil {
+		return nil, fmt.Errorf("parsers: reading substitutions: %w", err)
 	}
+
+	return newFileParserFromBytes(weightsBytes, substitutionsBytes), nil
 }
@@ -0,0 +1,123 @@
+package parsers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseWeightsLineFieldsRejectsMalformedLines(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{
+			name: "wrong field count",
+			line: "100000,100010,1,2,1,2,1,2,1,2,1,2,1,2,1",
+		},
+		{
+			name: "non-numeric sort code",
+			line: "10000a,100010,1,2,1,2,1,2,1,2,1,2,1,2,1,2,1",
+		},
+		{
+			name: "wrong-length sort code",
+			line: "10000,100010,1,2,1,2,1,2,1,2,1,2,1,2,1,2,1",
+		},
+		{
+			name: "range start after range end",
+			line: "100010,100000,1,2,1,2,1,2,1,2,1,2,1,2,1,2,1",
+		},
+		{
+			name: "out-of-range weight",
+			line: "100000,100010,1,2,1,2,1,2,1,2,1,2,1,2,1,100,1",
+		},
+		{
+			name: "non-numeric weight",
+			line: "100000,100010,1,2,1,2,1,2,1,2,1,2,1,2,1,x,1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseWeightsLineFields(LineRecord{content: tt.line, lineNumber: 1})
+			if err == nil {
+				t.Fatalf("parseWeightsLineFields(%q): expected an error, got nil", tt.line)
+			}
+		})
+	}
+}
+
+func TestParseWeightsLineFieldsAcceptsWellFormedLines(t *testing.T) {
+	scRange, err := parseWeightsLineFields(LineRecord{
+		content:    "100000,100010,1,2,1,2,1,2,1,2,1,2,1,2,1,2,1",
+		lineNumber: 3,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if scRange.Start != 100000 || scRange.End != 100010 {
+		t.Fatalf("unexpected range: %+v", scRange)
+	}
+	if len(scRange.Weights) != weightCount {
+		t.Fatalf("expected %d weights, got %d", weightCount, len(scRange.Weights))
+	}
+	if scRange.LineNumber != 3 {
+		t.Fatalf("expected line number 3, got %d", scRange.LineNumber)
+	}
+}
+
+func TestWeightsRejectsTripleOverlappingRange(t *testing.T) {
+	line := "100000,100005,1,2,1,2,1,2,1,2,1,2,1,2,1,2,1\n"
+	weights := strings.Repeat(line, 3)
+	substitutions := "100000 200000\n"
+
+	p, err := CreateFileParserFromReaders(strings.NewReader(weights), strings.NewReader(substitutions))
+	if err != nil {
+		t.Fatalf("CreateFileParserFromReaders: %v", err)
+	}
+
+	if _, err := p.Weights(); err == nil {
+		t.Fatal("expected an error for a sort code overlapping more than two ranges, got nil")
+	}
+}
+
+func TestWeightsAcceptsDoubleOverlappingRange(t *testing.T) {
+	line := "100000,100005,1,2,1,2,1,2,1,2,1,2,1,2,1,2,1\n"
+	weights := strings.Repeat(line, 2)
+	substitutions := "100000 200000\n"
+
+	p, err := CreateFileParserFromReaders(strings.NewReader(weights), strings.NewReader(substitutions))
+	if err != nil {
+		t.Fatalf("CreateFileParserFromReaders: %v", err)
+	}
+
+	scData, err := p.Weights()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if scData["100000"].Next == nil {
+		t.Fatal("expected the second range to be chained via Next")
+	}
+}
+
+func TestSubstitutionsRejectsMalformedLine(t *testing.T) {
+	_, err := CreateFileParserFromReaders(
+		strings.NewReader("100000,100010,1,2,1,2,1,2,1,2,1,2,1,2,1,2,1\n"),
+		strings.NewReader(""),
+	)
+	if err != nil {
+		t.Fatalf("CreateFileParserFromReaders: %v", err)
+	}
+
+	p, err := CreateFileParserFromReaders(
+		strings.NewReader("100000,100010,1,2,1,2,1,2,1,2,1,2,1,2,1,2,1\n"),
+		strings.NewReader("100000onlyonefield\n"),
+	)
+	if err != nil {
+		t.Fatalf("CreateFileParserFromReaders: %v", err)
+	}
+
+	if _, err := p.Substitutions(); err == nil {
+		t.Fatal("expected an error for a malformed substitutions line, got nil")
+	}
+}
@@ -0,0 +1,147 @@
+package parsers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	m "github.com/ntindall/moduluschecking/models"
+)
+
+// defaultFetchTimeout bounds a single fetch of the weights or
+// substitutions file when the caller hasn't supplied one via WithTimeout.
+const defaultFetchTimeout = 30 * time.Second
+
+// Option configures a Parser constructed from a remote source.
+type Option func(*remoteConfig)
+
+type remoteConfig struct {
+	timeout  time.Duration
+	cacheDir string
+	client   *http.Client
+}
+
+// WithTimeout bounds how long a single fetch of the weights or
+// substitutions file may take. Defaults to 30 seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *remoteConfig) { c.timeout = timeout }
+}
+
+// WithCacheDir enables on-disk caching of the fetched files under dir.
+// A cached copy's modification time is sent as If-Modified-Since on the
+// next fetch, so an unchanged upstream file can respond 304 Not Modified
+// and the cached copy is used instead of re-downloading it.
+func WithCacheDir(dir string) Option {
+	return func(c *remoteConfig) { c.cacheDir = dir }
+}
+
+// WithHTTPClient overrides the http.Client used to fetch remote files,
+// e.g. to inject a custom transport in tests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *remoteConfig) { c.client = client }
+}
+
+func newRemoteConfig(opts ...Option) *remoteConfig {
+	cfg := &remoteConfig{
+		timeout: defaultFetchTimeout,
+		client:  http.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return cfg
+}
+
+// CreateFileParserFromURL fetches the weights and substitutions files
+// over HTTP, e.g. directly from VocaLink, so a long-running service can
+// pick up periodic reference data updates without a rebuild. See
+// WithTimeout, WithCacheDir and WithHTTPClient to configure the fetch.
+func CreateFileParserFromURL(weightsURL, substitutionsURL string, opts ...Option) (m.Parser, error) {
+	cfg := newRemoteConfig(opts...)
+
+	weightsBytes, err := fetchWithCache(cfg, weightsURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsers: fetching weights from %s: %w", weightsURL, err)
+	}
+
+	substitutionsBytes, err := fetchWithCache(cfg, substitutionsURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsers: fetching substitutions from %s: %w", substitutionsURL, err)
+	}
+
+	return newFileParserFromBytes(weightsBytes, substitutionsBytes), nil
+}
+
+// fetchWithCache downloads url, bounded by cfg's timeout. When
+// cfg.cacheDir is set, it sends If-Modified-Since based on the cached
+// copy's mtime and falls back to that copy on a 304 response, so an
+// unchanged upstream file costs a conditional request rather than a full
+// download.
+func fetchWithCache(cfg *remoteConfig, url string) ([]byte, error) {
+	client := cfg.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var cachePath string
+	if cfg.cacheDir != "" {
+		cachePath = filepath.Join(cfg.cacheDir, cacheFileName(url))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if cachePath != "" {
+		if info, err := os.Stat(cachePath); err == nil {
+			req.Header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cachePath != "" {
+		return os.ReadFile(cachePath)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching %s: %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if cachePath != "" {
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+			return nil, fmt.Errorf("parsers: creating cache dir: %w", err)
+		}
+		if err := os.WriteFile(cachePath, body, 0o644); err != nil {
+			return nil, fmt.Errorf("parsers: writing cache file: %w", err)
+		}
+	}
+
+	return body, nil
+}
+
+// cacheFileName derives a filesystem-safe cache file name from a URL.
+func cacheFileName(url string) string {
+	replacer := strings.NewReplacer("/", "_", ":", "_", "?", "_", "&", "_")
+	return replacer.Replace(url)
+}
@@ -0,0 +1,188 @@
+package parsers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	m "github.com/ntindall/moduluschecking/models"
+)
+
+// DataSource produces fresh weights and substitutions readers each time
+// it is called, so a WatchingParser can re-read the underlying source -
+// a file path, a URL, or anything else - on every reload.
+type DataSource func() (weights io.Reader, substitutions io.Reader, err error)
+
+// FileDataSource builds a DataSource that re-opens the given weights and
+// substitutions file paths on every reload.
+func FileDataSource(weightsPath, substitutionsPath string) DataSource {
+	return func() (io.Reader, io.Reader, error) {
+		weights, err := os.Open(weightsPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsers: opening %s: %w", weightsPath, err)
+		}
+
+		substitutions, err := os.Open(substitutionsPath)
+		if err != nil {
+			weights.Close()
+			return nil, nil, fmt.Errorf("parsers: opening %s: %w", substitutionsPath, err)
+		}
+
+		return weights, substitutions, nil
+	}
+}
+
+// URLDataSource builds a DataSource that re-fetches the weights and
+// substitutions files from the given URLs on every reload, reusing the
+// timeout and caching behaviour configured via opts.
+func URLDataSource(weightsURL, substitutionsURL string, opts ...Option) DataSource {
+	cfg := newRemoteConfig(opts...)
+
+	return func() (io.Reader, io.Reader, error) {
+		weightsBytes, err := fetchWithCache(cfg, weightsURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsers: fetching weights from %s: %w", weightsURL, err)
+		}
+
+		substitutionsBytes, err := fetchWithCache(cfg, substitutionsURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsers: fetching substitutions from %s: %w", substitutionsURL, err)
+		}
+
+		return bytes.NewReader(weightsBytes), bytes.NewReader(substitutionsBytes), nil
+	}
+}
+
+// tables is one consistent, successfully-parsed snapshot of the
+// reference data. WatchingParser swaps the pointer to it atomically on
+// reload so readers never observe a partially-updated or emptied table.
+type tables struct {
+	weights       map[string]m.SortCodeData
+	substitutions map[string]string
+}
+
+// WatchingParser wraps a FileParser and periodically re-reads its
+// DataSource, atomically swapping in a freshly parsed snapshot so
+// Weights and Substitutions stay current without a service restart.
+// A failed reload is reported to onError and leaves the previous
+// snapshot in place.
+type WatchingParser struct {
+	source  DataSource
+	onError func(error)
+
+	current atomic.Pointer[tables]
+
+	ticker    *time.Ticker
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWatchingParser starts watching source, reloading every interval,
+// and returns a Parser backed by the most recently successful reload.
+// The first load happens synchronously; if it fails, onError is called
+// and the returned Parser starts out with empty tables, which later
+// reload ticks may still populate. Callers must call Close to stop the
+// background reload goroutine.
+func NewWatchingParser(source DataSource, interval time.Duration, onError func(error)) m.Parser {
+	wp := &WatchingParser{
+		source:  source,
+		onError: onError,
+		done:    make(chan struct{}),
+	}
+	wp.current.Store(&tables{
+		weights:       make(map[string]m.SortCodeData),
+		substitutions: make(map[string]string),
+	})
+
+	if err := wp.reload(); err != nil && wp.onError != nil {
+		wp.onError(fmt.Errorf("parsers: initial load: %w", err))
+	}
+
+	wp.ticker = time.NewTicker(interval)
+	go wp.watch()
+
+	return wp
+}
+
+// watch reloads on every tick until Close is called.
+func (wp *WatchingParser) watch() {
+	for {
+		select {
+		case <-wp.ticker.C:
+			if err := wp.reload(); err != nil && wp.onError != nil {
+				wp.onError(err)
+			}
+		case <-wp.done:
+			return
+		}
+	}
+}
+
+// reload re-reads the DataSource and, only if it parses cleanly end to
+// end, swaps it in as the current snapshot. A failed reload returns an
+// error and leaves the previously-good tables untouched.
+func (wp *WatchingParser) reload() error {
+	weightsReader, substitutionsReader, err := wp.source()
+	if err != nil {
+		return err
+	}
+	defer closeIfCloser(weightsReader)
+	defer closeIfCloser(substitutionsReader)
+
+	parser, err := CreateFileParserFromReaders(weightsReader, substitutionsReader)
+	if err != nil {
+		return err
+	}
+
+	weights, err := parser.Weights()
+	if err != nil {
+		return err
+	}
+
+	substitutions, err := parser.Substitutions()
+	if err != nil {
+		return err
+	}
+
+	wp.current.Store(&tables{weights: weights, substitutions: substitutions})
+
+	return nil
+}
+
+// closeIfCloser closes r if it implements io.Closer, so DataSources
+// backed by open files (e.g. FileDataSource) don't leak descriptors
+// across reloads.
+func closeIfCloser(r io.Reader) {
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// Weights returns the most recently successfully loaded weights table.
+// The read is lock-free: it loads an atomic snapshot pointer that a
+// concurrent reload swaps out, rather than mutating, so it never blocks
+// on or observes a reload in progress.
+func (wp *WatchingParser) Weights() (map[string]m.SortCodeData, error) {
+	return wp.current.Load().weights, nil
+}
+
+// Substitutions returns the most recently successfully loaded
+// substitutions table. See Weights for the lock-free read guarantee.
+func (wp *WatchingParser) Substitutions() (map[string]string, error) {
+	return wp.current.Load().substitutions, nil
+}
+
+// Close stops the background reload goroutine. It is safe to call
+// concurrently and more than once.
+func (wp *WatchingParser) Close() error {
+	wp.closeOnce.Do(func() {
+		close(wp.done)
+		wp.ticker.Stop()
+	})
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package parsers
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchingParserKeepsLastGoodTableOnFailedReload(t *testing.T) {
+	goodWeights := "100000,100010,1,2,1,2,1,2,1,2,1,2,1,2,1,2,1\n"
+	goodSubstitutions := "100000 200000\n"
+
+	var mu sync.Mutex
+	calls := 0
+	source := DataSource(func() (io.Reader, io.Reader, error) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+
+		if n == 1 {
+			return strings.NewReader(goodWeights), strings.NewReader(goodSubstitutions), nil
+		}
+		return nil, nil, errors.New("simulated source failure")
+	})
+
+	var errMu sync.Mutex
+	var gotErr error
+	onError := func(err error) {
+		errMu.Lock()
+		gotErr = err
+		errMu.Unlock()
+	}
+
+	parser := NewWatchingParser(source, 5*time.Millisecond, onError)
+	wp, ok := parser.(*WatchingParser)
+	if !ok {
+		t.Fatalf("NewWatchingParser returned %T, want *WatchingParser", parser)
+	}
+	defer wp.Close()
+
+	weights, err := wp.Weights()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(weights) == 0 {
+		t.Fatal("expected the initial load to populate the weights table")
+	}
+
+	deadline := time.After(500 * time.Millisecond)
+	for {
+		errMu.Lock()
+		failed := gotErr != nil
+		errMu.Unlock()
+		if failed {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("onError was never called for a failing reload")
+		case <-time.After(2 * time.Millisecond):
+		}
+	}
+
+	weightsAfterFailure, err := wp.Weights()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(weightsAfterFailure) != len(weights) {
+		t.Fatalf("expected the previous table to survive a failed reload, got %d entries, want %d", len(weightsAfterFailure), len(weights))
+	}
+}
+
+func TestWatchingParserCloseIsSafeForConcurrentCallers(t *testing.T) {
+	source := DataSource(func() (io.Reader, io.Reader, error) {
+		return strings.NewReader("100000,100010,1,2,1,2,1,2,1,2,1,2,1,2,1,2,1\n"), strings.NewReader("100000 200000\n"), nil
+	})
+
+	parser := NewWatchingParser(source, time.Hour, nil)
+	wp := parser.(*WatchingParser)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wp.Close()
+		}()
+	}
+	wg.Wait()
+}